@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"hash/fnv"
+	"io"
 	"log"
 	"math"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type s3op struct {
@@ -20,15 +27,195 @@ type s3op struct {
 	Size   uint64 `json:"size"`
 	Bucket string `json:"bucket"`
 	Key    string `json:"key"`
+	// Keys is only populated for a synthesized "deletebatch" op, holding the
+	// keys that were coalesced into a single s3.DeleteObjects call.
+	Keys []string `json:"-"`
+	// StorageClass and Tagging are optional and passed straight through to
+	// PutObjectInput on "put" ops when present; StorageClass is also applied
+	// to the destination of a "copy" op.
+	StorageClass string `json:"storageClass,omitempty"`
+	Tagging      string `json:"tagging,omitempty"`
+	// RestoreTier and RestoreDays configure a "restore" op's RestoreObject call.
+	RestoreTier string `json:"restoreTier,omitempty"`
+	RestoreDays int64  `json:"restoreDays,omitempty"`
+	// SourceBucket and SourceKey identify the object a "copy" op reads from;
+	// Bucket/Key above are the copy's destination.
+	SourceBucket string `json:"sourceBucket,omitempty"`
+	SourceKey    string `json:"sourceKey,omitempty"`
 }
 
+// maxDeleteBatch is the largest number of keys DeleteObjects accepts per call.
+const maxDeleteBatch = 1000
+
 type workerChan struct {
 	workChan chan s3op
 	wg       *sync.WaitGroup
 }
 
 var hasher = fnv.New64a()
-var operations = map[string]bool{"put": true, "get": true, "head": true, "updatemeta": true, "delete": true}
+var operations = map[string]bool{"put": true, "get": true, "head": true, "updatemeta": true, "delete": true, "multipart": true, "deletebatch": true, "restore": true, "copy": true}
+
+// partBufferPool hands out part-sized byte slices so that concurrent
+// multipart uploads don't each allocate their own scratch space.
+// flushInterval, when non-zero, periodically releases every buffer idle at
+// that moment back to the OS/GC instead of holding them indefinitely.
+//
+// The heap path (useMmap false) is backed by a sync.Pool; since sync.Pool is
+// not safe to copy once used (it embeds a noCopy guard), the active pool is
+// swapped via an atomic pointer rather than assigned by value, and flushing
+// just drops the old pool for the GC to reclaim once unreferenced.
+//
+// The mmap path is deliberately NOT a sync.Pool: mmap'd memory is invisible
+// to the GC, so a flush must synchronously munmap every idle buffer, and that
+// munmap must be impossible to race against a concurrent get() handing the
+// same buffer to a caller. A sync.Pool gives no way to make "remove from the
+// pool" and "is about to be reused" atomic with each other, so idle mmap
+// buffers instead live in a plain slice guarded by idleMu: get() pops and
+// flush() drains under that same lock and munmaps only the drained buffers,
+// so the two can never observe (let alone hand out and free) the same buffer.
+type partBufferPool struct {
+	partSize  int
+	useMmap   bool
+	active    atomic.Pointer[sync.Pool] // heap path only
+	flushStop chan struct{}
+
+	idleMu sync.Mutex
+	idle   [][]byte // mmap path only: buffers available for reuse
+}
+
+func newPartBufferPool(partSize int, useMmap bool, flushInterval time.Duration) *partBufferPool {
+	p := &partBufferPool{partSize: partSize, useMmap: useMmap}
+	if !useMmap {
+		p.active.Store(p.newPool())
+	}
+	if flushInterval > 0 {
+		p.flushStop = make(chan struct{})
+		go p.flushLoop(flushInterval)
+	}
+	return p
+}
+
+func (p *partBufferPool) newPool() *sync.Pool {
+	return &sync.Pool{New: func() interface{} {
+		return make([]byte, p.partSize)
+	}}
+}
+
+func (p *partBufferPool) mmapBuffer() []byte {
+	buf, err := syscall.Mmap(-1, 0, p.partSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		log.Fatalf("unable to mmap part buffer: %v", err)
+	}
+	return buf
+}
+
+// flushLoop periodically releases idle buffers so they stop being reused:
+// for the heap path that means swapping in a fresh sync.Pool, for the mmap
+// path it means munmapping whatever was idle at the time.
+func (p *partBufferPool) flushLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.flush()
+		case <-p.flushStop:
+			return
+		}
+	}
+}
+
+func (p *partBufferPool) flush() {
+	if !p.useMmap {
+		p.active.Store(p.newPool())
+		return
+	}
+	p.idleMu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.idleMu.Unlock()
+	for _, buf := range idle {
+		if err := syscall.Munmap(buf); err != nil {
+			log.Printf("failed to munmap idle part buffer: %v", err)
+		}
+	}
+}
+
+func (p *partBufferPool) get() []byte {
+	if !p.useMmap {
+		return p.active.Load().Get().([]byte)
+	}
+	p.idleMu.Lock()
+	defer p.idleMu.Unlock()
+	if n := len(p.idle); n > 0 {
+		buf := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		return buf
+	}
+	return p.mmapBuffer()
+}
+
+func (p *partBufferPool) put(buf []byte) {
+	if !p.useMmap {
+		p.active.Load().Put(buf)
+		return
+	}
+	p.idleMu.Lock()
+	p.idle = append(p.idle, buf)
+	p.idleMu.Unlock()
+}
+
+// partReader wraps a pooled buffer so it can be handed to the SDK as an
+// io.ReadSeeker for UploadPart, returning the buffer to the pool on Close so
+// the next part to be uploaded can reuse it instead of allocating anew.
+type partReader struct {
+	buf  []byte
+	off  int
+	pool *partBufferPool
+}
+
+func newPartReader(pool *partBufferPool, n int) *partReader {
+	buf := pool.get()
+	return &partReader{buf: buf[:n], pool: pool}
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	if r.off >= len(r.buf) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func (r *partReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		r.off = int(offset)
+	case io.SeekCurrent:
+		r.off += int(offset)
+	case io.SeekEnd:
+		r.off = len(r.buf) + int(offset)
+	}
+	return int64(r.off), nil
+}
+
+func (r *partReader) Close() error {
+	r.pool.put(r.buf[:cap(r.buf)])
+	return nil
+}
+
+// multipartParams holds the tunables for multipart uploads, threaded down to
+// doMultipartUpload.
+type multipartParams struct {
+	partSize          int64
+	uploadConcurrency int
+	bufferPool        *partBufferPool
+	// opDeadline bounds how long a single multipart upload (all its parts) may
+	// run, sourced from the same --op-deadline flag as every other op type, so
+	// a stalled object can't block a worker indefinitely.
+	opDeadline time.Duration
+}
 
 type workloadParams struct {
 	// keeps track of keys that have already been hashed to a specific worker
@@ -40,12 +227,19 @@ type workloadParams struct {
 	workersChanSlice []*workerChan
 	concurrency      int
 	credentials      *credentials.Credentials
+	// deleteBuffers coalesces consecutive deletes for the same (worker, bucket)
+	// pair so they can be issued as a single DeleteObjects call instead of one
+	// HTTP DELETE per key. Keyed by "<workerNum>:<bucket>".
+	deleteBuffers map[string][]string
+	// deleteBatchSize caps how many keys accumulate before a batch is flushed,
+	// defaulting to the AWS DeleteObjects limit of maxDeleteBatch.
+	deleteBatchSize int
 }
 
 func setupWorkloadParams(workerChans []*workerChan, concurrency int, credential *credentials.Credentials) *workloadParams {
 	keys := make(map[string]uint64)
 	buckets := make(map[string]bool)
-	return &workloadParams{hashKeys: keys, bucketMap: buckets, workersChanSlice: workerChans, concurrency: concurrency, credentials: credential}
+	return &workloadParams{hashKeys: keys, bucketMap: buckets, workersChanSlice: workerChans, concurrency: concurrency, credentials: credential, deleteBuffers: make(map[string][]string), deleteBatchSize: maxDeleteBatch}
 }
 
 func closeAllWorkerChannels(workChanSlice []*workerChan) {
@@ -81,10 +275,28 @@ func parseFileReplay(args *parameters, opsChan chan []s3op) {
 	return
 }
 
+// deriveRunContext builds the context the CLI entrypoint passes into SetupOps:
+// it's cancelled on SIGINT/SIGTERM, and additionally bounded by args.duration
+// (the --duration flag) when set, so a wall-clock run budget cancels in-flight
+// S3 calls the same way an interrupt would. Callers must invoke the returned
+// cancel func (e.g. via defer) once the run completes to release the signal
+// notification and any timeout goroutine.
+func deriveRunContext(args *parameters) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	if args.duration <= 0 {
+		return ctx, stop
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, args.duration)
+	return ctx, func() {
+		cancelTimeout()
+		stop()
+	}
+}
+
 // Parses file and checks to see if worload type is one of {mixed,replay}
 // If Replay -> continue streaming in the json file to determine which s3 operations to execute
 // If Mixed -> Read in json file to a struct to determine which s3 operations to generate and then execute
-func SetupOps(args *parameters, workerChans []*workerChan, credential *credentials.Credentials) error {
+func SetupOps(ctx context.Context, args *parameters, workerChans []*workerChan, credential *credentials.Credentials) error {
 	workloadParams := setupWorkloadParams(workerChans, args.concurrency, credential)
 
 	if _, err := args.jsonDecoder.Token(); err != nil {
@@ -98,9 +310,9 @@ func SetupOps(args *parameters, workerChans []*workerChan, credential *credentia
 	}
 	switch workType {
 	case "mixedWorkload":
-		MixedWorkload(args, workloadParams)
+		MixedWorkload(ctx, args, workloadParams)
 	case "replay":
-		Replay(args, workloadParams)
+		Replay(ctx, args, workloadParams)
 	default:
 		log.Fatal("Incorrect workload type specified, must be one of 'mixedWorkload' or 'replay'")
 	}
@@ -108,8 +320,10 @@ func SetupOps(args *parameters, workerChans []*workerChan, credential *credentia
 }
 
 // Starts receiving on the []S3op channel which takes in slice of S3ops and sends
-// each s3op to a worker based on hashValue of Name
-func Replay(args *parameters, workload *workloadParams) {
+// each s3op to a worker based on hashValue of Name. Cancelling ctx (SIGINT/SIGTERM
+// or an elapsed --duration budget) stops dispatching further ops and lets the
+// worker channels drain via closeAllWorkerChannels instead of blocking forever.
+func Replay(ctx context.Context, args *parameters, workload *workloadParams) {
 	s3opsChan := make(chan []s3op, 1000)
 	go func() {
 		parseFileReplay(args, s3opsChan)
@@ -118,13 +332,28 @@ func Replay(args *parameters, workload *workloadParams) {
 		close(s3opsChan)
 	}()
 
-	for ops := range s3opsChan {
-		splitS3ops(workload, ops, args.endpoints[0], args.region)
+loop:
+	for {
+		select {
+		case ops, ok := <-s3opsChan:
+			if !ok {
+				break loop
+			}
+			if !splitS3ops(ctx, workload, ops, args.endpoints[0], args.region) {
+				break loop
+			}
+		case <-ctx.Done():
+			break loop
+		}
 	}
+	flushAllDeleteBuffers(ctx, workload)
 }
 
-// Splits up each []s3op into single s3op and sends to approriate worker
-func splitS3ops(params *workloadParams, ops []s3op, endpoint string, region string) {
+// Splits up each []s3op into single s3op and sends to approriate worker.
+// Consecutive deletes targeting the same (worker, bucket) pair are coalesced
+// into a single "deletebatch" op instead of being sent one key at a time.
+// Returns false if ctx was cancelled before all ops in this batch were sent.
+func splitS3ops(ctx context.Context, params *workloadParams, ops []s3op, endpoint string, region string) bool {
 	for _, op := range ops {
 		workerNum := getHashKey(params.hashKeys, op.Bucket+op.Key, params.concurrency)
 //		op.Bucket = fmt.Sprintf("%s-%d", op.Bucket, workerNum)
@@ -136,7 +365,83 @@ func splitS3ops(params *workloadParams, ops []s3op, endpoint string, region stri
 			}
 		}
 */
-		params.workersChanSlice[workerNum].workChan <- op
+		if op.Event == "delete" {
+			if !bufferDelete(ctx, params, workerNum, op.Bucket, op.Key) {
+				return false
+			}
+			continue
+		}
+		if !flushWorkerDeleteBuffers(ctx, params, workerNum) {
+			return false
+		}
+		select {
+		case params.workersChanSlice[workerNum].workChan <- op:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// bufferDelete appends key to the pending delete batch for (workerNum, bucket),
+// flushing it immediately if it has reached deleteBatchSize. Returns false if
+// ctx was cancelled while flushing.
+func bufferDelete(ctx context.Context, params *workloadParams, workerNum int, bucket string, key string) bool {
+	bufKey := fmt.Sprintf("%d:%s", workerNum, bucket)
+	params.deleteBuffers[bufKey] = append(params.deleteBuffers[bufKey], key)
+	if len(params.deleteBuffers[bufKey]) >= params.deleteBatchSize {
+		return flushDeleteBuffer(ctx, params, workerNum, bucket)
+	}
+	return true
+}
+
+// flushDeleteBuffer sends the pending delete batch for (workerNum, bucket), if
+// any, to the worker as a single "deletebatch" op. The send races ctx.Done()
+// so a cancelled/shutting-down run can't block forever waiting on a worker
+// that has already stopped reading its channel; on cancellation the batch is
+// dropped and false is returned so callers can stop flushing further batches.
+func flushDeleteBuffer(ctx context.Context, params *workloadParams, workerNum int, bucket string) bool {
+	bufKey := fmt.Sprintf("%d:%s", workerNum, bucket)
+	keys := params.deleteBuffers[bufKey]
+	if len(keys) == 0 {
+		return true
+	}
+	delete(params.deleteBuffers, bufKey)
+	select {
+	case params.workersChanSlice[workerNum].workChan <- s3op{Event: "deletebatch", Bucket: bucket, Keys: keys}:
+		return true
+	case <-ctx.Done():
+		log.Printf("shutdown: dropping %d buffered deletes for bucket %s", len(keys), bucket)
+		return false
+	}
+}
+
+// flushWorkerDeleteBuffers flushes every pending delete batch belonging to
+// workerNum, e.g. when a non-delete op for that worker arrives.
+func flushWorkerDeleteBuffers(ctx context.Context, params *workloadParams, workerNum int) bool {
+	prefix := fmt.Sprintf("%d:", workerNum)
+	for bufKey, keys := range params.deleteBuffers {
+		if len(keys) == 0 || !strings.HasPrefix(bufKey, prefix) {
+			continue
+		}
+		bucket := strings.TrimPrefix(bufKey, prefix)
+		if !flushDeleteBuffer(ctx, params, workerNum, bucket) {
+			return false
+		}
+	}
+	return true
+}
+
+// flushAllDeleteBuffers flushes every pending delete batch across all workers,
+// used once an input stream is exhausted so no buffered deletes are dropped.
+func flushAllDeleteBuffers(ctx context.Context, params *workloadParams) {
+	for bufKey := range params.deleteBuffers {
+		idx := strings.Index(bufKey, ":")
+		workerNum, err := strconv.Atoi(bufKey[:idx])
+		if err != nil {
+			log.Fatalf("corrupt delete buffer key %q: %v", bufKey, err)
+		}
+		flushDeleteBuffer(ctx, params, workerNum, bufKey[idx+1:])
 	}
 }
 
@@ -145,12 +450,19 @@ type opTrack struct {
 	sent   int64
 	Optype string `json:"operationType"`
 	Ratio  int    `json:"ratio"`
+	// BatchSize, when set on a "delete" ratio entry, overrides how many keys
+	// are coalesced into a single DeleteObjects call (default maxDeleteBatch).
+	BatchSize int `json:"batchSize"`
+	// StorageClass, when set on a "put" ratio entry, is stamped onto every
+	// generated put so a single mixed workload can target several storage
+	// classes, e.g. 70% STANDARD / 20% STANDARD_IA / 10% GLACIER.
+	StorageClass string `json:"storageClass,omitempty"`
 }
 
 // Main mixedWorkload function, creates a struct to track relative ratios
-func MixedWorkload(args *parameters, workloadParams *workloadParams) {
+func MixedWorkload(ctx context.Context, args *parameters, workloadParams *workloadParams) {
 	ratios := parseFileMixed(args)
-	generateRequests(args, ratios, workloadParams)
+	generateRequests(ctx, args, ratios, workloadParams)
 }
 
 // Parses mixedReplayFile into a struct
@@ -162,9 +474,13 @@ func parseFileMixed(args *parameters) []opTrack {
 		}
 	}
 	totalPerc := 0
-	for _, v := range ratios {
+	for i := range ratios {
+		v := &ratios[i]
 		if _, ok := operations[v.Optype]; !ok {
-			log.Fatalf("Mixed workload operation types must be one of {'put','get','delete','updatemeta','head'}, but got %v", v.Optype)
+			log.Fatalf("Mixed workload operation types must be one of {'put','get','delete','updatemeta','head','multipart','deletebatch','restore','copy'}, but got %v", v.Optype)
+		}
+		if v.Optype == "delete" && v.BatchSize <= 0 {
+			v.BatchSize = maxDeleteBatch
 		}
 		v.ops = ((float64(args.nrequests.value) * float64(v.Ratio)) / float64(100))
 		totalPerc += v.Ratio
@@ -179,26 +495,39 @@ func parseFileMixed(args *parameters) []opTrack {
 // Generates a workload 100 mixed operations at a time. For instance, if 50% Put and 50% Get specified,
 // It will generate 50 Puts and send them. Then it will generate 50 Gets and
 // send them. It will repeat this until the number of requests specified is reached.
-func generateRequests(args *parameters, ratios []opTrack, workload *workloadParams) {
+func generateRequests(ctx context.Context, args *parameters, ratios []opTrack, workload *workloadParams) {
 	sent := 0
 	totalOps := args.nrequests.value
+outer:
 	for j := 0; j < int(math.Ceil(float64(totalOps)/100.0)); j++ {
 		// Send in batches of 100, However if leftover is < 100
 		// adjust the operation's ratio accordingly
 		leftover := math.Min(100.0, float64(totalOps-sent))
-		for _, v := range ratios {
-			for i := 0; i < int(math.Floor((float64(v.Ratio)/100.0)*leftover)); i++ {
+		for i := range ratios {
+			v := &ratios[i]
+			if v.Optype == "delete" && v.BatchSize > 0 {
+				workload.deleteBatchSize = v.BatchSize
+			}
+			for j := 0; j < int(math.Floor((float64(v.Ratio)/100.0)*leftover)); j++ {
 				op := s3op{Event: v.Optype, Size: uint64(args.osize), Bucket: args.bucketname, Key: args.objectprefix + "-" + strconv.FormatInt(v.sent, 10)}
+				if v.Optype == "put" {
+					op.StorageClass = v.StorageClass
+				}
 				sent += 1
 				v.sent += 1
-				sendS3op(op, workload, args.endpoints[0], args.region)
+				if !sendS3op(ctx, op, workload, args.endpoints[0], args.region) {
+					break outer
+				}
 			}
 		}
 	}
+	flushAllDeleteBuffers(ctx, workload)
 }
 
-// Sends s3op to appropriate worker for mixedWorkload
-func sendS3op(op s3op, params *workloadParams, endpoint string, region string) {
+// Sends s3op to appropriate worker for mixedWorkload. Deletes are buffered and
+// coalesced the same way as in splitS3ops rather than sent one key at a time.
+// Returns false if ctx was cancelled before op could be handed to its worker.
+func sendS3op(ctx context.Context, op s3op, params *workloadParams, endpoint string, region string) bool {
 	workerNum := getHashKey(params.hashKeys, op.Key+op.Bucket, params.concurrency)
 //	op.Bucket = fmt.Sprintf("%s-%d", op.Bucket, workerNum)
 /*
@@ -209,7 +538,229 @@ func sendS3op(op s3op, params *workloadParams, endpoint string, region string) {
 		}
 	}
 */
-	params.workersChanSlice[workerNum].workChan <- op
+	if op.Event == "delete" {
+		return bufferDelete(ctx, params, workerNum, op.Bucket, op.Key)
+	}
+	if !flushWorkerDeleteBuffers(ctx, params, workerNum) {
+		return false
+	}
+	select {
+	case params.workersChanSlice[workerNum].workChan <- op:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// doMultipartUpload performs a true S3 multipart upload for op, splitting the
+// object into partSize chunks drawn from the shared bufferPool and uploading
+// up to uploadConcurrency parts at a time. If any part fails, the upload is
+// explicitly aborted so S3 doesn't retain an incomplete multipart upload.
+// ctx carries the overall run's cancellation/--duration deadline; mp.opDeadline,
+// when non-zero, additionally bounds how long this single op may run so a
+// slow object can't stall a worker indefinitely.
+func doMultipartUpload(ctx context.Context, svc *s3.S3, op s3op, mp *multipartParams) error {
+	if mp.opDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mp.opDeadline)
+		defer cancel()
+	}
+
+	created, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(op.Bucket),
+		Key:    aws.String(op.Key),
+	})
+	if err != nil {
+		return err
+	}
+	uploadID := created.UploadId
+
+	nparts := int(math.Ceil(float64(op.Size) / float64(mp.partSize)))
+	completed := make([]*s3.CompletedPart, nparts)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, mp.uploadConcurrency)
+	errs := make(chan error, nparts)
+
+	for i := 0; i < nparts; i++ {
+		partNum := i + 1
+		partLen := mp.partSize
+		if remaining := int64(op.Size) - int64(i)*mp.partSize; remaining < partLen {
+			partLen = remaining
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(partNum int, partLen int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs <- uploadPart(ctx, svc, op, uploadID, mp, partNum, partLen, completed)
+		}(partNum, partLen)
+	}
+	wg.Wait()
+	close(errs)
+
+	// Part completion order is nondeterministic, so every result must be
+	// drained before deciding whether to complete or abort: stopping at the
+	// first value read can miss a later part's failure, leaving a nil
+	// CompletedPart in `completed` and completing an upload that should have
+	// been aborted.
+	var firstErr error
+	for err := range errs {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		if _, abortErr := svc.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(op.Bucket),
+			Key:      aws.String(op.Key),
+			UploadId: uploadID,
+		}); abortErr != nil {
+			log.Printf("failed to abort multipart upload for %s/%s: %v", op.Bucket, op.Key, abortErr)
+		}
+		return firstErr
+	}
+
+	_, err = svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(op.Bucket),
+		Key:             aws.String(op.Key),
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+// uploadPart reads a single part out of the pooled buffer and uploads it.
+// Following the same cancellation pattern as read-side keepstore clients: the
+// SDK call runs in a goroutine and this select races it against ctx.Done(),
+// so a cancelled run doesn't block waiting on a slow/stuck part. If ctx fires
+// first, the upload goroutine still closes body once the call returns, so the
+// pooled buffer is released back via body.Close() instead of leaking.
+func uploadPart(ctx context.Context, svc *s3.S3, op s3op, uploadID *string, mp *multipartParams, partNum int, partLen int64, completed []*s3.CompletedPart) error {
+	body := newPartReader(mp.bufferPool, int(partLen))
+	ready := make(chan error, 1)
+	go func() {
+		defer body.Close()
+		result, err := svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(op.Bucket),
+			Key:        aws.String(op.Key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(int64(partNum)),
+			Body:       body,
+		})
+		if err != nil {
+			ready <- err
+			return
+		}
+		completed[partNum-1] = &s3.CompletedPart{ETag: result.ETag, PartNumber: aws.Int64(int64(partNum))}
+		ready <- nil
+	}()
+
+	select {
+	case err := <-ready:
+		return err
+	case <-ctx.Done():
+		go func() { <-ready }()
+		return ctx.Err()
+	}
+}
+
+// putObjectInput builds a PutObjectInput for op, carrying through the optional
+// storage class and tagging set on a "put" s3op.
+func putObjectInput(op s3op, body io.ReadSeeker) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(op.Bucket),
+		Key:    aws.String(op.Key),
+		Body:   body,
+	}
+	if op.StorageClass != "" {
+		input.StorageClass = aws.String(op.StorageClass)
+	}
+	if op.Tagging != "" {
+		input.Tagging = aws.String(op.Tagging)
+	}
+	return input
+}
+
+// doRestoreObject issues a RestoreObject (POST ?restore) call for op, using
+// op.RestoreTier/op.RestoreDays if set or the tier/days defaults otherwise.
+func doRestoreObject(ctx context.Context, svc *s3.S3, op s3op, defaultTier string, defaultDays int64) error {
+	tier := op.RestoreTier
+	if tier == "" {
+		tier = defaultTier
+	}
+	days := op.RestoreDays
+	if days == 0 {
+		days = defaultDays
+	}
+	_, err := svc.RestoreObjectWithContext(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(op.Bucket),
+		Key:    aws.String(op.Key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(days),
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(tier),
+			},
+		},
+	})
+	return err
+}
+
+// deleteBatchResult gives the per-key outcome of a doDeleteBatch call so a
+// batch of N keys is accounted for as N individual deletes, not one unit.
+type deleteBatchResult struct {
+	Succeeded int
+	Failed    int
+}
+
+// doDeleteBatch issues a single s3.DeleteObjects call for the keys coalesced
+// onto a "deletebatch" op and reports per-key success/failure from the
+// response's Deleted/Errors arrays, so a batch is never counted as one
+// all-or-nothing unit when only some of its keys actually failed.
+func doDeleteBatch(ctx context.Context, svc *s3.S3, op s3op) (deleteBatchResult, error) {
+	objects := make([]*s3.ObjectIdentifier, len(op.Keys))
+	for i, key := range op.Keys {
+		objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+	}
+	result, err := svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(op.Bucket),
+		Delete: &s3.Delete{Objects: objects},
+	})
+	if err != nil {
+		return deleteBatchResult{}, err
+	}
+	for _, failed := range result.Errors {
+		log.Printf("delete failed for %s/%s: %s", op.Bucket, aws.StringValue(failed.Key), aws.StringValue(failed.Message))
+	}
+	batchResult := deleteBatchResult{Succeeded: len(result.Deleted), Failed: len(result.Errors)}
+	if batchResult.Failed > 0 {
+		return batchResult, fmt.Errorf("%d of %d keys failed to delete from bucket %s", batchResult.Failed, len(op.Keys), op.Bucket)
+	}
+	return batchResult, nil
+}
+
+// copySource builds the Bucket/Key pair CopyObjectInput expects in its
+// CopySource field. The key is path-encoded (not query-encoded) per the
+// SDK's CopySource contract: query-escaping turns spaces into "+", which S3
+// does not decode back to a space in CopySource. url.PathEscape still encodes
+// any "/" inside the key as "%2F".
+func copySource(bucket, key string) string {
+	return bucket + "/" + url.PathEscape(key)
+}
+
+// doCopyObject performs a server-side copy from op.SourceBucket/op.SourceKey
+// into op.Bucket/op.Key, optionally transitioning the copy to op.StorageClass.
+func doCopyObject(ctx context.Context, svc *s3.S3, op s3op) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(op.Bucket),
+		Key:        aws.String(op.Key),
+		CopySource: aws.String(copySource(op.SourceBucket, op.SourceKey)),
+	}
+	if op.StorageClass != "" {
+		input.StorageClass = aws.String(op.StorageClass)
+	}
+	_, err := svc.CopyObjectWithContext(ctx, input)
+	return err
 }
 
 // creates a new Bucket
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// runWorker drains wc.workChan, executing each s3op against svc, until the
+// channel is closed (normal/shutdown completion, see closeAllWorkerChannels).
+// Cancelling ctx aborts in-flight calls but does not stop the loop; it returns
+// only once the channel closes. It calls wc.wg.Done() on return so callers
+// can wg.Wait() for every worker to drain.
+//
+// mp carries the shared multipart tunables, including the per-op deadline
+// (mp.opDeadline) applied to every op type so a single slow object can't
+// stall this worker indefinitely; restoreTier/restoreDays are the
+// workload-wide "restore" defaults used when an op doesn't specify its own.
+func runWorker(ctx context.Context, wc *workerChan, svc *s3.S3, mp *multipartParams, restoreTier string, restoreDays int64) {
+	defer wc.wg.Done()
+	for op := range wc.workChan {
+		if err := dispatchOp(ctx, svc, op, mp, restoreTier, restoreDays); err != nil {
+			log.Printf("s3 op %s %s/%s failed: %v", op.Event, op.Bucket, op.Key, err)
+		}
+	}
+}
+
+// dispatchOp executes a single s3op, applying mp.opDeadline as a per-op
+// context timeout around whichever call the op maps to.
+func dispatchOp(ctx context.Context, svc *s3.S3, op s3op, mp *multipartParams, restoreTier string, restoreDays int64) error {
+	opCtx := ctx
+	if mp.opDeadline > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, mp.opDeadline)
+		defer cancel()
+	}
+
+	switch op.Event {
+	case "put":
+		// mp.bufferPool hands out partSize-length slices for multipart parts,
+		// not whole objects, so a put whose Size exceeds partSize must not
+		// draw from it (newPartReader would slice out of bounds and panic).
+		body := bytes.NewReader(make([]byte, op.Size))
+		_, err := svc.PutObjectWithContext(opCtx, putObjectInput(op, body))
+		return err
+	case "get":
+		result, err := svc.GetObjectWithContext(opCtx, &s3.GetObjectInput{Bucket: aws.String(op.Bucket), Key: aws.String(op.Key)})
+		if err != nil {
+			return err
+		}
+		return result.Body.Close()
+	case "head":
+		_, err := svc.HeadObjectWithContext(opCtx, &s3.HeadObjectInput{Bucket: aws.String(op.Bucket), Key: aws.String(op.Key)})
+		return err
+	case "updatemeta":
+		_, err := svc.CopyObjectWithContext(opCtx, &s3.CopyObjectInput{
+			Bucket:            aws.String(op.Bucket),
+			Key:               aws.String(op.Key),
+			CopySource:        aws.String(copySource(op.Bucket, op.Key)),
+			Metadata:          map[string]*string{"updated": aws.String(metadataValue(int(op.Size)))},
+			MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		})
+		return err
+	case "delete":
+		_, err := svc.DeleteObjectWithContext(opCtx, &s3.DeleteObjectInput{Bucket: aws.String(op.Bucket), Key: aws.String(op.Key)})
+		return err
+	case "deletebatch":
+		result, err := doDeleteBatch(opCtx, svc, op)
+		log.Printf("deletebatch %s: %d succeeded, %d failed", op.Bucket, result.Succeeded, result.Failed)
+		return err
+	case "multipart":
+		// doMultipartUpload applies mp.opDeadline itself, derived from the
+		// un-timed ctx (it fans out across many part uploads), so pass ctx.
+		return doMultipartUpload(ctx, svc, op, mp)
+	case "restore":
+		return doRestoreObject(opCtx, svc, op, restoreTier, restoreDays)
+	case "copy":
+		return doCopyObject(opCtx, svc, op)
+	default:
+		return fmt.Errorf("unknown s3 op %q", op.Event)
+	}
+}